@@ -1,15 +1,24 @@
 package rawdb
 
 import (
+	"encoding/binary"
+	"fmt"
+	"iter"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
+// borTxIndexerIndexedMeter tracks the rate at which the BorTxIndexer writes
+// new bor tx lookup entries.
+var borTxIndexerIndexedMeter = metrics.NewRegisteredMeter("rawdb/bortxindexer/indexed", nil)
+
 var (
 	// bor receipt key
 	borReceiptKey = types.BorReceiptKey
@@ -87,36 +96,51 @@ func ReadRawBorReceipt(db ethdb.Reader, hash common.Hash, number uint64) *types.
 	if data == nil || len(data) == 0 {
 		return nil
 	}
+	return decodeBorReceiptRLP(data, hash, number)
+}
 
-	// Convert the receipts from their storage form to their internal representation
+// decodeBorReceiptRLP converts a bor receipt from its storage form to its
+// internal representation.
+func decodeBorReceiptRLP(data []byte, hash common.Hash, number uint64) *types.Receipt {
 	// [mys] additional receipts logic to handle incorrect ones
 	var storageReceipt types.ReceiptForStorage
-	if err := rlp.DecodeBytes(data, &storageReceipt); err != nil {
-		storageReceipts := []*types.ReceiptForStorage{}
-		if err := rlp.DecodeBytes(data, &storageReceipts); err != nil {
-			log.Error("Invalid bor receipt array RLP", "number", number, "hash", hash, "err", err)
-			return nil
-		}
-		if nReceipts := len(storageReceipts); nReceipts != 1 {
-			log.Error("Invalid bor receipt array RLP length", "number", number, "hash", hash, "nReceipts", nReceipts)
-			return nil
-		}
-		return (*types.Receipt)(storageReceipts[0])
+	if err := rlp.DecodeBytes(data, &storageReceipt); err == nil {
+		return (*types.Receipt)(&storageReceipt)
 	}
 
-	return (*types.Receipt)(&storageReceipt)
+	storageReceipts := []*types.ReceiptForStorage{}
+	if err := rlp.DecodeBytes(data, &storageReceipts); err != nil {
+		log.Error("Invalid bor receipt RLP", "number", number, "hash", hash, "err", err)
+		return nil
+	}
+	if nReceipts := len(storageReceipts); nReceipts != 1 {
+		log.Error("Invalid bor receipt array RLP length", "number", number, "hash", hash, "nReceipts", nReceipts)
+		return nil
+	}
+	return (*types.Receipt)(storageReceipts[0])
 }
 
 // ReadBorReceipt retrieves all the bor block receipts belonging to a block, including
 // its correspoinding metadata fields. If it is unable to populate these metadata
-// fields then nil is returned.
-func ReadBorReceipt(db ethdb.Reader, hash common.Hash, number uint64) *types.Receipt {
-	// We're deriving many fields from the block body, retrieve beside the receipt
+// fields then nil is returned. From config.BorReceiptFieldsBlock on, the chain config
+// is used so that fork-dependent fields (typed receipts post-London, EIP-1559
+// effective gas price, ...) are derived using the rules active at the given block;
+// a nil config, or a block before BorReceiptFieldsBlock, gets the legacy derivation.
+func ReadBorReceipt(db ethdb.Reader, hash common.Hash, number uint64, config *params.ChainConfig) *types.Receipt {
 	borReceipt := ReadRawBorReceipt(db, hash, number)
 	if borReceipt == nil {
 		return nil
 	}
+	return deriveBorReceipt(db, hash, number, borReceipt, config)
+}
 
+// deriveBorReceipt fills in the metadata fields of a raw bor receipt -
+// Type, EffectiveGasPrice and the per-log positional fields - using the
+// canonical receipts of the same block, honoring the fork rules in config
+// as gated by borReceiptFieldsConfig. It returns nil if the block's receipts
+// or body are missing (logging an error only for a missing body), or if the
+// derivation fails.
+func deriveBorReceipt(db ethdb.Reader, hash common.Hash, number uint64, borReceipt *types.Receipt, config *params.ChainConfig) *types.Receipt {
 	// We're deriving many fields from the block body, retrieve beside the receipt
 	receipts := ReadRawReceipts(db, hash, number)
 	if receipts == nil {
@@ -129,13 +153,140 @@ func ReadBorReceipt(db ethdb.Reader, hash common.Hash, number uint64) *types.Rec
 		return nil
 	}
 
-	if err := types.DeriveFieldsForBorReceipt(borReceipt, hash, number, receipts); err != nil {
+	if err := types.DeriveFieldsForBorReceipt(borReceipt, hash, number, receipts, borReceiptFieldsConfig(config, number)); err != nil {
 		log.Error("Failed to derive bor receipt fields", "hash", hash, "number", number, "err", err)
 		return nil
 	}
 	return borReceipt
 }
 
+// borReceiptFieldsConfig gates the richer, fork-aware bor receipt derivation
+// behind config.BorReceiptFieldsBlock, the same way every other fork field on
+// params.ChainConfig treats an unset block number as "never activated": if
+// BorReceiptFieldsBlock is nil - true of every chain config that predates
+// this field - or number hasn't reached it yet, derivation falls back to the
+// legacy nil-config behavior so already-stored historical receipts aren't
+// reinterpreted differently. Only once the configured block has been reached
+// is the real config passed through so DeriveFieldsForBorReceipt can honor
+// the active fork rules.
+func borReceiptFieldsConfig(config *params.ChainConfig, number uint64) *params.ChainConfig {
+	if config == nil || config.BorReceiptFieldsBlock == nil {
+		return nil
+	}
+	if number < config.BorReceiptFieldsBlock.Uint64() {
+		return nil
+	}
+	return config
+}
+
+// borReceiptsRangeFreezerThreshold is the minimum number of fully-frozen
+// blocks in a ReadBorReceiptsRange call below which the bulk ancient-range
+// path isn't worth its own two AncientRange calls; narrower frozen
+// sub-ranges fall back to per-block reads instead.
+const borReceiptsRangeFreezerThreshold = 32
+
+// ReadBorReceiptsRange streams the derived bor receipts for blocks
+// [start, end), in order. For the portion of the range that is fully frozen,
+// once it's at least borReceiptsRangeFreezerThreshold blocks, it reads the
+// bor receipts and block hashes with one AncientRange call each instead of
+// one lookup per block; narrower frozen sub-ranges and the unfrozen tail,
+// which has no bulk accessor, fall back to per-block KV lookups. Iteration
+// stops early if the consumer's yield function returns false.
+func ReadBorReceiptsRange(db ethdb.Reader, start, end uint64, config *params.ChainConfig) iter.Seq2[uint64, *types.Receipt] {
+	return func(yield func(uint64, *types.Receipt) bool) {
+		if start >= end {
+			return
+		}
+
+		frozen, _ := db.Ancients()
+		frozenEnd := end
+		if frozen < frozenEnd {
+			frozenEnd = frozen
+		}
+
+		if start < frozenEnd {
+			if frozenEnd-start >= borReceiptsRangeFreezerThreshold {
+				if !streamFrozenBorReceipts(db, start, frozenEnd, config, yield) {
+					return
+				}
+			} else {
+				for number := start; number < frozenEnd; number++ {
+					if !yieldBorReceipt(db, number, config, yield) {
+						return
+					}
+				}
+			}
+		}
+
+		for number := frozenEnd; number < end; number++ {
+			if !yieldBorReceipt(db, number, config, yield) {
+				return
+			}
+		}
+	}
+}
+
+// streamFrozenBorReceipts bulk-reads the bor receipts and canonical hashes
+// for [start, end) from the freezer and yields each derived receipt. If
+// either bulk read fails or comes back short, it falls back to per-block
+// reads for the whole sub-range itself. It returns false as soon as yield
+// does, and true otherwise.
+func streamFrozenBorReceipts(db ethdb.Reader, start, end uint64, config *params.ChainConfig, yield func(uint64, *types.Receipt) bool) bool {
+	count := end - start
+	receiptsRLP, err := db.AncientRange(freezerBorReceiptTable, start, count, 0)
+	if err != nil || uint64(len(receiptsRLP)) != count {
+		for number := start; number < end; number++ {
+			if !yieldBorReceipt(db, number, config, yield) {
+				return false
+			}
+		}
+		return true
+	}
+
+	hashes, err := db.AncientRange(freezerHashTable, start, count, 0)
+	if err != nil || len(hashes) != len(receiptsRLP) {
+		for number := start; number < end; number++ {
+			if !yieldBorReceipt(db, number, config, yield) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i, data := range receiptsRLP {
+		number := start + uint64(i)
+		hash := common.BytesToHash(hashes[i])
+
+		borReceipt := decodeBorReceiptRLP(data, hash, number)
+		if borReceipt == nil {
+			continue
+		}
+		receipt := deriveBorReceipt(db, hash, number, borReceipt, config)
+		if receipt == nil {
+			continue
+		}
+		if !yield(number, receipt) {
+			return false
+		}
+	}
+	return true
+}
+
+// yieldBorReceipt reads and derives the bor receipt for a single block and,
+// if one exists, passes it to yield. It reports whether iteration should
+// continue.
+func yieldBorReceipt(db ethdb.Reader, number uint64, config *params.ChainConfig, yield func(uint64, *types.Receipt) bool) bool {
+	hash := ReadCanonicalHash(db, number)
+	if hash == (common.Hash{}) {
+		return true
+	}
+	receipt := ReadBorReceipt(db, hash, number, config)
+	if receipt == nil {
+		return true
+	}
+	return yield(number, receipt)
+}
+
 // WriteBorReceipt stores all the bor receipt belonging to a block.
 func WriteBorReceipt(db ethdb.KeyValueWriter, hash common.Hash, number uint64, borReceipt *types.ReceiptForStorage) {
 	// Convert the bor receipt into their storage form and serialize them
@@ -150,6 +301,100 @@ func WriteBorReceipt(db ethdb.KeyValueWriter, hash common.Hash, number uint64, b
 	}
 }
 
+// BorReceiptEntry pairs a block's hash/number with the bor receipt to store
+// for it, used by WriteBorReceipts to commit many blocks' worth of bor
+// receipts in one go.
+type BorReceiptEntry struct {
+	Hash    common.Hash
+	Number  uint64
+	Receipt *types.ReceiptForStorage
+}
+
+// WriteBorReceipts stores the given bor receipts in a single batched commit,
+// instead of issuing one leveldb write per block as WriteBorReceipt does.
+func WriteBorReceipts(db ethdb.KeyValueStore, entries []BorReceiptEntry) {
+	batch := db.NewBatch()
+	for _, entry := range entries {
+		bytes, err := rlp.EncodeToBytes(entry.Receipt)
+		if err != nil {
+			log.Crit("Failed to encode bor receipt", "err", err)
+		}
+		if err := batch.Put(borReceiptKey(entry.Number, entry.Hash), bytes); err != nil {
+			log.Crit("Failed to batch bor receipt", "err", err)
+		}
+	}
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to write bor receipts batch", "err", err)
+	}
+}
+
+// AppendBorReceiptToAncient appends a single bor receipt to the
+// freezerBorReceiptTable, symmetric with the per-block ancient append used
+// for canonical receipts.
+func AppendBorReceiptToAncient(op ethdb.AncientWriteOp, number uint64, receipt *types.ReceiptForStorage) error {
+	bytes, err := rlp.EncodeToBytes(receipt)
+	if err != nil {
+		return fmt.Errorf("failed to encode bor receipt %d for ancient store: %w", number, err)
+	}
+	return op.AppendRaw(freezerBorReceiptTable, number, bytes)
+}
+
+// FreezeBorReceipt appends the bor receipt stored for (hash, number) to the
+// bor receipts ancient table via op and, if one was found, deletes its
+// leveldb copy. It is meant to be called exactly once per block, from the
+// same freezer goroutine that freezes canonical blocks and receipts
+// (freezerBlocks/freezerReceipts), right after the canonical receipt at
+// number has been frozen, so the two tables stay in lock-step. It must be
+// the only call site appending to freezerBorReceiptTable for a given chain;
+// if the chain freezer already has its own bor-receipt-freezing logic
+// elsewhere, that logic must be replaced with a call to this function rather
+// than left to run alongside it, or the table's item count will desync from
+// the block number the first time the two paths disagree on a block.
+//
+// Every block gets exactly one entry here, even the (common) case where it
+// never had a bor receipt: the freezer requires strictly sequential appends,
+// so skipping an index would desync freezerBorReceiptTable's item counter
+// from the block number as soon as the next real bor receipt is appended.
+// Blocks without a bor receipt get an empty placeholder, which
+// ReadBorReceiptRLP already treats as "not found" via its len(data) > 0
+// checks.
+func FreezeBorReceipt(db ethdb.KeyValueStore, op ethdb.AncientWriteOp, hash common.Hash, number uint64) error {
+	key := borReceiptKey(number, hash)
+
+	data, _ := db.Get(key)
+	if err := op.AppendRaw(freezerBorReceiptTable, number, data); err != nil {
+		return fmt.Errorf("failed to freeze bor receipt %d: %w", number, err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return db.Delete(key)
+}
+
+// PruneBorReceiptsAbove returns an error if reorgBlock reaches into bor
+// receipts that have already been moved to the ancient store, since frozen
+// data cannot be deleted in place. Callers should reject such a reorg rather
+// than attempt to prune it.
+func PruneBorReceiptsAbove(db ethdb.AncientReader, reorgBlock uint64) error {
+	frozen, err := db.Ancients()
+	if err != nil {
+		return err
+	}
+	if frozen > 0 && reorgBlock < frozen {
+		return fmt.Errorf("cannot reorg to block %d: bor receipts are frozen up to block %d", reorgBlock, frozen-1)
+	}
+	return nil
+}
+
+// TruncateBorReceiptsHead truncates the bor receipts ancient table so that it
+// no longer holds any entry at or above number. It is only safe to call this
+// after PruneBorReceiptsAbove has confirmed the truncation doesn't reach into
+// already-frozen data that other tables still reference.
+func TruncateBorReceiptsHead(op ethdb.AncientWriter, number uint64) error {
+	_, err := op.TruncateHead(number)
+	return err
+}
+
 // DeleteBorReceipt removes receipt data associated with a block hash.
 func DeleteBorReceipt(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
 	key := borReceiptKey(number, hash)
@@ -204,8 +449,22 @@ func ReadBorTransaction(db ethdb.Reader, hash common.Hash) (*types.Transaction,
 // Indexes for reverse lookup
 //
 
+// encodeBlockNumber encodes number as a fixed-width, big-endian 8-byte slice,
+// so that bor tx lookup values are ordered and length-prefix-consistent with
+// the rest of the schema, matching upstream's WriteTxLookupEntries encoding.
+func encodeBlockNumber(number uint64) []byte {
+	enc := make([]byte, 8)
+	binary.BigEndian.PutUint64(enc, number)
+	return enc
+}
+
 // ReadBorTxLookupEntry retrieves the positional metadata associated with a transaction
 // hash to allow retrieving the bor transaction or bor receipt using tx hash.
+//
+// The stored value is decoded via big.Int so that both the legacy
+// variable-length big.Int.Bytes() encoding and the new fixed 8-byte
+// big-endian encoding from encodeBlockNumber parse to the same number,
+// covering the transitional window where both forms are present on disk.
 func ReadBorTxLookupEntry(db ethdb.Reader, txHash common.Hash) *uint64 {
 	data, _ := db.Get(borTxLookupKey(txHash))
 	if len(data) == 0 {
@@ -219,11 +478,60 @@ func ReadBorTxLookupEntry(db ethdb.Reader, txHash common.Hash) *uint64 {
 // WriteBorTxLookupEntry stores a positional metadata for bor transaction using block hash and block number
 func WriteBorTxLookupEntry(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
 	txHash := types.GetDerivedBorTxHash(borReceiptKey(number, hash))
-	if err := db.Put(borTxLookupKey(txHash), big.NewInt(0).SetUint64(number).Bytes()); err != nil {
+	if err := db.Put(borTxLookupKey(txHash), encodeBlockNumber(number)); err != nil {
 		log.Crit("Failed to store bor transaction lookup entry", "err", err)
 	}
 }
 
+// BorTxLookupEntry pairs a block's hash/number so WriteBorTxLookupEntries can
+// derive and store many bor tx lookup entries through one batch.
+type BorTxLookupEntry struct {
+	BlockHash common.Hash
+	Number    uint64
+}
+
+// WriteBorTxLookupEntries stores the given bor tx lookup entries through a
+// batch, instead of issuing one leveldb write per block as
+// WriteBorTxLookupEntry does. The batch is committed whenever it reaches
+// ethdb.IdealBatchSize and once more at the end, so a large set of entries
+// is written in several commits, not atomically.
+func WriteBorTxLookupEntries(db ethdb.KeyValueStore, entries []BorTxLookupEntry) {
+	batch := db.NewBatch()
+	for _, entry := range entries {
+		txHash := types.GetDerivedBorTxHash(borReceiptKey(entry.Number, entry.BlockHash))
+		if err := batch.Put(borTxLookupKey(txHash), encodeBlockNumber(entry.Number)); err != nil {
+			log.Crit("Failed to batch bor transaction lookup entry", "err", err)
+		}
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				log.Crit("Failed to write bor transaction lookup entries batch", "err", err)
+			}
+			batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to write bor transaction lookup entries batch", "err", err)
+	}
+}
+
+// DeleteBorTxLookupEntriesRange removes the bor tx lookup entry for every
+// block in [from, to), used to prune entries belonging to blocks dropped by
+// a reorg or aged out of a BorTxIndexer's trailing window. Each block's
+// lookup key is derived and deleted directly from its canonical hash via
+// DeleteBorTxLookupEntry, mirroring how the canonical TxIndexer prunes its
+// own window, instead of scanning the entire matic-bor-tx-lookup- keyspace
+// to find the handful of entries that actually aged out.
+func DeleteBorTxLookupEntriesRange(db ethdb.Database, from, to uint64) error {
+	for number := from; number < to; number++ {
+		hash := ReadCanonicalHash(db, number)
+		if hash == (common.Hash{}) {
+			continue
+		}
+		DeleteBorTxLookupEntry(db, hash, number)
+	}
+	return nil
+}
+
 // DeleteBorTxLookupEntry removes bor transaction data associated with block hash and block number
 func DeleteBorTxLookupEntry(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
 	txHash := types.GetDerivedBorTxHash(borReceiptKey(number, hash))
@@ -236,3 +544,68 @@ func DeleteBorTxLookupEntryByTxHash(db ethdb.KeyValueWriter, txHash common.Hash)
 		log.Crit("Failed to delete bor transaction lookup entry", "err", err)
 	}
 }
+
+//
+// BorTxIndexer
+//
+
+// borTxIndexProgressKey tracks the highest block number the BorTxIndexer has
+// indexed, as reported by ReadBorTxIndexProgress. BorTxIndexer.Process only
+// writes it; nothing here reads it back to resume indexing after a restart,
+// and no RPC exposes it.
+var borTxIndexProgressKey = []byte("LastBorTxIndexedBlock")
+
+// ReadBorTxIndexProgress returns the highest block number the BorTxIndexer
+// has indexed, or 0 if indexing has not started.
+func ReadBorTxIndexProgress(db ethdb.KeyValueReader) uint64 {
+	data, _ := db.Get(borTxIndexProgressKey)
+	if len(data) == 0 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// WriteBorTxIndexProgress records number as the highest block the
+// BorTxIndexer has indexed.
+func WriteBorTxIndexProgress(db ethdb.KeyValueWriter, number uint64) {
+	if err := db.Put(borTxIndexProgressKey, encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to store bor tx index progress", "err", err)
+	}
+}
+
+// BorTxIndexer lazily maintains the bor tx lookup index over a trailing
+// window of txLookupLimit blocks, mirroring the canonical TxIndexer: as the
+// chain grows, newly canonical blocks are indexed and entries that have
+// fallen out of the window are pruned. A limit of 0 indexes (and retains)
+// the entire chain.
+type BorTxIndexer struct {
+	limit uint64
+	db    ethdb.Database
+}
+
+// NewBorTxIndexer creates a bor tx lookup indexer bounded to the trailing
+// txLookupLimit blocks.
+func NewBorTxIndexer(db ethdb.Database, txLookupLimit uint64) *BorTxIndexer {
+	return &BorTxIndexer{limit: txLookupLimit, db: db}
+}
+
+// Process indexes the given bor tx lookup entries - which must belong to
+// newly canonical blocks up to and including head - and, once head exceeds
+// the configured limit, prunes entries that have fallen out of the trailing
+// window.
+func (indexer *BorTxIndexer) Process(entries []BorTxLookupEntry, head uint64) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	WriteBorTxLookupEntries(indexer.db, entries)
+	borTxIndexerIndexedMeter.Mark(int64(len(entries)))
+
+	if indexer.limit != 0 && head >= indexer.limit {
+		tail := head - indexer.limit + 1
+		if err := DeleteBorTxLookupEntriesRange(indexer.db, 0, tail); err != nil {
+			return fmt.Errorf("failed to prune bor tx lookup entries below %d: %w", tail, err)
+		}
+	}
+	WriteBorTxIndexProgress(indexer.db, head)
+	return nil
+}
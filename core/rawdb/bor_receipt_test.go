@@ -0,0 +1,451 @@
+package rawdb
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// memKV is a minimal in-memory stand-in for ethdb.Database used to exercise
+// the bor receipt/tx-lookup helpers without a real leveldb or freezer
+// instance. ReadCanonicalHash (defined elsewhere in this package) falls back
+// to "not found" against it, since nothing in this package populates the
+// canonical-hash keys it reads - that's enough to exercise everything below
+// except canonical-hash-driven pruning/derivation. The ancients map backs a
+// tiny real freezer so the bulk AncientRange path can be exercised too.
+type memKV struct {
+	data              map[string][]byte
+	ancients          map[string]map[uint64][]byte
+	ancientRangeCalls int
+}
+
+func newMemKV() *memKV {
+	return &memKV{data: make(map[string][]byte), ancients: make(map[string]map[uint64][]byte)}
+}
+
+// putAncient seeds a single item into the given ancient table, for tests
+// that exercise the freezer bulk-read paths.
+func (db *memKV) putAncient(kind string, number uint64, item []byte) {
+	table, ok := db.ancients[kind]
+	if !ok {
+		table = make(map[uint64][]byte)
+		db.ancients[kind] = table
+	}
+	table[number] = item
+}
+
+func (db *memKV) Has(key []byte) (bool, error) {
+	_, ok := db.data[string(key)]
+	return ok, nil
+}
+
+func (db *memKV) Get(key []byte) ([]byte, error) {
+	if v, ok := db.data[string(key)]; ok {
+		return common.CopyBytes(v), nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (db *memKV) Put(key, value []byte) error {
+	db.data[string(key)] = common.CopyBytes(value)
+	return nil
+}
+
+func (db *memKV) Delete(key []byte) error {
+	delete(db.data, string(key))
+	return nil
+}
+
+func (db *memKV) Stat() (string, error) { return "", nil }
+
+func (db *memKV) Compact(start, limit []byte) error { return nil }
+
+func (db *memKV) Close() error { return nil }
+
+func (db *memKV) NewBatch() ethdb.Batch { return &memBatch{db: db} }
+
+func (db *memKV) NewBatchWithSize(int) ethdb.Batch { return &memBatch{db: db} }
+
+func (db *memKV) NewIterator(prefix, start []byte) ethdb.Iterator {
+	var keys []string
+	for k := range db.data {
+		if bytes.HasPrefix([]byte(k), prefix) && bytes.Compare([]byte(k), append(prefix, start...)) >= 0 {
+			keys = append(keys, k)
+		}
+	}
+	return &memIterator{db: db, keys: keys, pos: -1}
+}
+
+func (db *memKV) HasAncient(kind string, number uint64) (bool, error) {
+	_, ok := db.ancients[kind][number]
+	return ok, nil
+}
+
+func (db *memKV) Ancient(kind string, number uint64) ([]byte, error) {
+	item, ok := db.ancients[kind][number]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return item, nil
+}
+
+func (db *memKV) AncientRange(kind string, start, count, maxBytes uint64) ([][]byte, error) {
+	db.ancientRangeCalls++
+	items := make([][]byte, 0, count)
+	for number := start; number < start+count; number++ {
+		item, ok := db.ancients[kind][number]
+		if !ok {
+			break
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Ancients reports the number of items in the bor receipt table, which is
+// the only ancient table the code under test cares about the length of.
+func (db *memKV) Ancients() (uint64, error) {
+	return uint64(len(db.ancients[freezerBorReceiptTable])), nil
+}
+func (db *memKV) Tail() (uint64, error)                   { return 0, nil }
+func (db *memKV) AncientSize(kind string) (uint64, error) { return 0, nil }
+func (db *memKV) AncientDatadir() (string, error)         { return "", nil }
+func (db *memKV) Sync() error                             { return nil }
+func (db *memKV) TruncateHead(n uint64) (uint64, error)   { return n, nil }
+func (db *memKV) TruncateTail(n uint64) (uint64, error)   { return n, nil }
+func (db *memKV) ModifyAncients(fn func(ethdb.AncientWriteOp) error) (int64, error) {
+	return 0, fn(&recordingAncientWriteOp{})
+}
+
+type memIterator struct {
+	db   *memKV
+	keys []string
+	pos  int
+}
+
+func (it *memIterator) Next() bool {
+	it.pos++
+	return it.pos < len(it.keys)
+}
+
+func (it *memIterator) Error() error { return nil }
+
+func (it *memIterator) Key() []byte { return []byte(it.keys[it.pos]) }
+
+func (it *memIterator) Value() []byte { return it.db.data[it.keys[it.pos]] }
+
+func (it *memIterator) Release() {}
+
+type memBatch struct {
+	db   *memKV
+	ops  []func()
+	size int
+}
+
+func (b *memBatch) Put(key, value []byte) error {
+	k, v := string(key), common.CopyBytes(value)
+	b.ops = append(b.ops, func() { b.db.data[k] = v })
+	b.size += len(key) + len(value)
+	return nil
+}
+
+func (b *memBatch) Delete(key []byte) error {
+	k := string(key)
+	b.ops = append(b.ops, func() { delete(b.db.data, k) })
+	b.size += len(key)
+	return nil
+}
+
+func (b *memBatch) ValueSize() int { return b.size }
+
+func (b *memBatch) Write() error {
+	for _, op := range b.ops {
+		op()
+	}
+	return nil
+}
+
+func (b *memBatch) Reset() {
+	b.ops = nil
+	b.size = 0
+}
+
+func (b *memBatch) Replay(w ethdb.KeyValueWriter) error { return nil }
+
+// recordingAncientWriteOp is a bare-bones ethdb.AncientWriteOp that just
+// records every AppendRaw call it receives, so tests can assert on exactly
+// which (kind, number) pairs were appended.
+type recordingAncientWriteOp struct {
+	appends []recordedAppend
+}
+
+type recordedAppend struct {
+	kind   string
+	number uint64
+	size   int
+}
+
+func (op *recordingAncientWriteOp) Append(kind string, number uint64, item interface{}) error {
+	return nil
+}
+
+func (op *recordingAncientWriteOp) AppendRaw(kind string, number uint64, item []byte) error {
+	op.appends = append(op.appends, recordedAppend{kind: kind, number: number, size: len(item)})
+	return nil
+}
+
+// TestDecodeBorReceiptRLPSingle checks that a bor receipt persisted in the
+// single types.ReceiptForStorage form - the form WriteBorReceipt/
+// WriteBorReceipts actually write - round-trips through decodeBorReceiptRLP.
+func TestDecodeBorReceiptRLPSingle(t *testing.T) {
+	hash := common.HexToHash("0x1")
+	number := uint64(42)
+
+	receipt := &types.ReceiptForStorage{
+		Status:            types.ReceiptStatusSuccessful,
+		CumulativeGasUsed: 21000,
+		Logs: []*types.Log{
+			{
+				Address: common.HexToAddress("0xaa"),
+				Topics:  []common.Hash{common.HexToHash("0xbb")},
+				Data:    []byte{1, 2, 3},
+			},
+		},
+	}
+
+	data, err := rlp.EncodeToBytes(receipt)
+	if err != nil {
+		t.Fatalf("failed to encode bor receipt: %v", err)
+	}
+
+	decoded := decodeBorReceiptRLP(data, hash, number)
+	if decoded == nil {
+		t.Fatalf("failed to decode bor receipt")
+	}
+	if decoded.Status != receipt.Status {
+		t.Errorf("status mismatch: got %d, want %d", decoded.Status, receipt.Status)
+	}
+	if decoded.CumulativeGasUsed != receipt.CumulativeGasUsed {
+		t.Errorf("cumulative gas used mismatch: got %d, want %d", decoded.CumulativeGasUsed, receipt.CumulativeGasUsed)
+	}
+	if len(decoded.Logs) != 1 ||
+		decoded.Logs[0].Address != receipt.Logs[0].Address ||
+		decoded.Logs[0].Topics[0] != receipt.Logs[0].Topics[0] ||
+		!bytes.Equal(decoded.Logs[0].Data, receipt.Logs[0].Data) {
+		t.Errorf("logs mismatch: got %+v, want %+v", decoded.Logs, receipt.Logs)
+	}
+}
+
+// TestDecodeBorReceiptRLPArray checks that the legacy single-element
+// []*types.ReceiptForStorage form some older blocks were persisted in still
+// decodes correctly.
+func TestDecodeBorReceiptRLPArray(t *testing.T) {
+	hash := common.HexToHash("0x2")
+	number := uint64(7)
+
+	legacy := []*types.ReceiptForStorage{
+		{
+			Status:            types.ReceiptStatusFailed,
+			CumulativeGasUsed: 1000,
+			Logs: []*types.Log{
+				{Address: common.HexToAddress("0xcc")},
+			},
+		},
+	}
+
+	data, err := rlp.EncodeToBytes(legacy)
+	if err != nil {
+		t.Fatalf("failed to encode legacy bor receipt array: %v", err)
+	}
+
+	decoded := decodeBorReceiptRLP(data, hash, number)
+	if decoded == nil {
+		t.Fatalf("failed to decode legacy bor receipt array")
+	}
+	if decoded.Status != legacy[0].Status {
+		t.Errorf("status mismatch: got %d, want %d", decoded.Status, legacy[0].Status)
+	}
+	if decoded.CumulativeGasUsed != legacy[0].CumulativeGasUsed {
+		t.Errorf("cumulative gas used mismatch: got %d, want %d", decoded.CumulativeGasUsed, legacy[0].CumulativeGasUsed)
+	}
+	if len(decoded.Logs) != 1 || decoded.Logs[0].Address != legacy[0].Logs[0].Address {
+		t.Errorf("logs mismatch: got %+v, want %+v", decoded.Logs, legacy[0].Logs)
+	}
+}
+
+// TestBorReceiptFieldsConfig checks that borReceiptFieldsConfig falls back to
+// the legacy nil-config derivation whenever BorReceiptFieldsBlock is unset or
+// hasn't been reached yet, and only passes the real config through once the
+// configured block has been reached.
+func TestBorReceiptFieldsConfig(t *testing.T) {
+	if got := borReceiptFieldsConfig(nil, 100); got != nil {
+		t.Errorf("nil config: got %v, want nil", got)
+	}
+
+	noField := &params.ChainConfig{}
+	if got := borReceiptFieldsConfig(noField, 100); got != nil {
+		t.Errorf("config without BorReceiptFieldsBlock: got %v, want nil", got)
+	}
+
+	gated := &params.ChainConfig{BorReceiptFieldsBlock: big.NewInt(100)}
+	if got := borReceiptFieldsConfig(gated, 50); got != nil {
+		t.Errorf("block before BorReceiptFieldsBlock: got %v, want nil", got)
+	}
+	if got := borReceiptFieldsConfig(gated, 100); got != gated {
+		t.Errorf("block at BorReceiptFieldsBlock: got %v, want %v", got, gated)
+	}
+	if got := borReceiptFieldsConfig(gated, 200); got != gated {
+		t.Errorf("block after BorReceiptFieldsBlock: got %v, want %v", got, gated)
+	}
+}
+
+// TestFreezeBorReceiptAlwaysAppends checks that FreezeBorReceipt appends an
+// entry to the ancient table for every block, even one that never had a bor
+// receipt, so the table's item index stays aligned with the block number.
+func TestFreezeBorReceiptAlwaysAppends(t *testing.T) {
+	db := newMemKV()
+	op := &recordingAncientWriteOp{}
+
+	hashWithReceipt := common.HexToHash("0x1")
+	hashWithoutReceipt := common.HexToHash("0x2")
+
+	borReceipt := &types.ReceiptForStorage{Status: types.ReceiptStatusSuccessful}
+	encoded, err := rlp.EncodeToBytes(borReceipt)
+	if err != nil {
+		t.Fatalf("failed to encode bor receipt: %v", err)
+	}
+	if err := db.Put(borReceiptKey(10, hashWithReceipt), encoded); err != nil {
+		t.Fatalf("failed to seed bor receipt: %v", err)
+	}
+
+	// Block 10 has a bor receipt, block 11 does not.
+	if err := FreezeBorReceipt(db, op, hashWithReceipt, 10); err != nil {
+		t.Fatalf("FreezeBorReceipt(10): %v", err)
+	}
+	if err := FreezeBorReceipt(db, op, hashWithoutReceipt, 11); err != nil {
+		t.Fatalf("FreezeBorReceipt(11): %v", err)
+	}
+
+	if len(op.appends) != 2 {
+		t.Fatalf("expected an ancient append for every block, got %d", len(op.appends))
+	}
+	if op.appends[0].number != 10 || op.appends[0].size == 0 {
+		t.Errorf("expected a non-empty append at block 10, got %+v", op.appends[0])
+	}
+	if op.appends[1].number != 11 || op.appends[1].size != 0 {
+		t.Errorf("expected an empty placeholder append at block 11, got %+v", op.appends[1])
+	}
+
+	if has, _ := db.Has(borReceiptKey(10, hashWithReceipt)); has {
+		t.Errorf("leveldb copy of a frozen bor receipt should have been deleted")
+	}
+}
+
+// TestEncodeBlockNumberLegacyCompat checks that the fixed 8-byte big-endian
+// encoding written by encodeBlockNumber and the legacy variable-length
+// big.Int.Bytes() encoding it replaced both decode to the same block number
+// through ReadBorTxLookupEntry, so entries written before and after the
+// schema change remain readable side by side.
+func TestEncodeBlockNumberLegacyCompat(t *testing.T) {
+	db := newMemKV()
+	txHashFixed := common.HexToHash("0x3")
+	txHashLegacy := common.HexToHash("0x4")
+
+	number := uint64(123456789)
+	if err := db.Put(borTxLookupKey(txHashFixed), encodeBlockNumber(number)); err != nil {
+		t.Fatalf("failed to seed fixed-width entry: %v", err)
+	}
+	if err := db.Put(borTxLookupKey(txHashLegacy), new(big.Int).SetUint64(number).Bytes()); err != nil {
+		t.Fatalf("failed to seed legacy entry: %v", err)
+	}
+
+	got := ReadBorTxLookupEntry(db, txHashFixed)
+	if got == nil || *got != number {
+		t.Errorf("fixed-width entry: got %v, want %d", got, number)
+	}
+	got = ReadBorTxLookupEntry(db, txHashLegacy)
+	if got == nil || *got != number {
+		t.Errorf("legacy entry: got %v, want %d", got, number)
+	}
+}
+
+// TestBorTxIndexerProcessIndexesAndTracksProgress checks that Process writes
+// the given entries, records indexing progress, and - once head exceeds the
+// configured window - attempts to prune the aged-out tail. The pruning step
+// depends on ReadCanonicalHash, which lives outside this package's checkout,
+// so against memKV it resolves every historical block to the zero hash and
+// is a no-op; what's verified here is that Process neither errors nor skips
+// writing progress when that happens.
+func TestBorTxIndexerProcessIndexesAndTracksProgress(t *testing.T) {
+	db := newMemKV()
+	indexer := NewBorTxIndexer(db, 10)
+
+	entries := []BorTxLookupEntry{
+		{BlockHash: common.HexToHash("0x5"), Number: 20},
+	}
+	if err := indexer.Process(entries, 20); err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+
+	txHash := types.GetDerivedBorTxHash(borReceiptKey(20, common.HexToHash("0x5")))
+	got := ReadBorTxLookupEntry(db, txHash)
+	if got == nil || *got != 20 {
+		t.Errorf("expected lookup entry for block 20, got %v", got)
+	}
+	if progress := ReadBorTxIndexProgress(db); progress != 20 {
+		t.Errorf("progress: got %d, want 20", progress)
+	}
+}
+
+// seedFrozenBorReceipt seeds one block's bor receipt and canonical hash into
+// the freezer tables, as FreezeBorReceipt would once it has run.
+func seedFrozenBorReceipt(t *testing.T, db *memKV, number uint64, hash common.Hash, status uint64) {
+	t.Helper()
+	receipt := &types.ReceiptForStorage{Status: status}
+	data, err := rlp.EncodeToBytes(receipt)
+	if err != nil {
+		t.Fatalf("failed to encode bor receipt for block %d: %v", number, err)
+	}
+	db.putAncient(freezerBorReceiptTable, number, data)
+	db.putAncient(freezerHashTable, number, hash.Bytes())
+}
+
+// TestReadBorReceiptsRangeFreezerThreshold checks that ReadBorReceiptsRange
+// only takes the bulk AncientRange path once the frozen portion of the
+// range meets borReceiptsRangeFreezerThreshold, falling back to per-block
+// reads below it.
+//
+// Deriving a full receipt also needs ReadRawReceipts/ReadBody, which are
+// defined elsewhere in this package and aren't present in this file alone,
+// so both paths yield zero receipts here; what's verified is routing, not
+// the derived output.
+func TestReadBorReceiptsRangeFreezerThreshold(t *testing.T) {
+	db := newMemKV()
+
+	const wide = borReceiptsRangeFreezerThreshold + 2
+	for number := uint64(0); number < wide; number++ {
+		hash := common.BigToHash(new(big.Int).SetUint64(number + 1))
+		seedFrozenBorReceipt(t, db, number, hash, types.ReceiptStatusSuccessful)
+	}
+
+	for range ReadBorReceiptsRange(db, 0, wide, nil) {
+	}
+	if db.ancientRangeCalls == 0 {
+		t.Errorf("expected the bulk AncientRange path to run for a %d-block frozen range", wide)
+	}
+
+	db.ancientRangeCalls = 0
+	const narrow = borReceiptsRangeFreezerThreshold - 1
+	for range ReadBorReceiptsRange(db, 0, narrow, nil) {
+	}
+	if db.ancientRangeCalls != 0 {
+		t.Errorf("expected the per-block fallback path for a %d-block frozen range, but AncientRange was called %d times", narrow, db.ancientRangeCalls)
+	}
+}